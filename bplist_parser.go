@@ -0,0 +1,411 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unicode/utf16"
+)
+
+// bplistMagic is the 8-byte header that opens every binary plist.
+var bplistMagic = []byte("bplist00")
+
+// bplistEpoch is the reference date for binary plist <date> objects:
+// 2001-01-01 00:00:00 UTC, seconds offset from which are stored as a
+// big-endian float64.
+var bplistEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// UID is the Go representation of a binary plist "UID" object (marker
+// 0x8), used by NSKeyedArchiver to reference another entry in $objects.
+// It decodes as a distinct type rather than a plain integer so that
+// UnmarshalKeyedArchive can tell a reference apart from an ordinary
+// number with the same value.
+type UID uint64
+
+// bplistDict is the decoded form of a binary plist dict object: parallel
+// key/value slices in on-disk order, mirroring how the XML decoder walks
+// <key> and its following value in lockstep.
+type bplistDict struct {
+	keys []string
+	vals []interface{}
+}
+
+// bplistSource abstracts the byte storage a binary plist is decoded from,
+// so the same object-reading code can walk an in-memory slice or, for
+// large files, seek through an io.ReaderAt on demand instead of holding
+// the whole document in memory.
+type bplistSource interface {
+	// readAt returns the n bytes at offset off, or an error if they run
+	// past the end of the source.
+	readAt(off, n int) ([]byte, error)
+	// size returns the total length of the source.
+	size() int
+}
+
+// bplistSliceSource is a bplistSource backed by an in-memory byte slice,
+// used by unmarshalBinary.
+type bplistSliceSource []byte
+
+func (s bplistSliceSource) readAt(off, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off+n > len(s) {
+		return nil, fmt.Errorf("bplist: read past end of data")
+	}
+	return s[off : off+n], nil
+}
+
+func (s bplistSliceSource) size() int { return len(s) }
+
+// unmarshalBinary decodes a bplist00 document into v, using the same
+// struct-tag field matching and slice/struct shape that unmarshalValue
+// uses for the XML format.
+func unmarshalBinary(data []byte, v reflect.Value) error {
+	return decodeBplist(bplistSliceSource(data), v)
+}
+
+// decodeBplist reads the trailer and offset table from src, then decodes
+// the top object into v.
+func decodeBplist(src bplistSource, v reflect.Value) error {
+	obj, err := bplistTree(src)
+	if err != nil {
+		return err
+	}
+	return assignPlistValue(obj, v)
+}
+
+// bplistTree reads the trailer and offset table from src and decodes the
+// top object into the generic representation, without assigning it into
+// any particular Go value. UnmarshalKeyedArchive uses this directly so it
+// can walk the $objects table itself.
+func bplistTree(src bplistSource) (interface{}, error) {
+	if src.size() < len(bplistMagic)+32 {
+		return nil, fmt.Errorf("bplist: data too short")
+	}
+	trailer, err := src.readAt(src.size()-32, 32)
+	if err != nil {
+		return nil, err
+	}
+	offsetIntSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	topObject := binary.BigEndian.Uint64(trailer[16:24])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if offsetIntSize == 0 || objectRefSize == 0 {
+		return nil, fmt.Errorf("bplist: invalid trailer")
+	}
+
+	offsets := make([]uint64, numObjects)
+	for i := range offsets {
+		b, err := src.readAt(int(offsetTableOffset)+i*offsetIntSize, offsetIntSize)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: offset table runs past end of data")
+		}
+		offsets[i] = bplistReadUint(b)
+	}
+
+	return bplistReadObject(src, offsets, objectRefSize, topObject)
+}
+
+// bplistReadUint reads a big-endian unsigned integer from b, as used for
+// both offset-table entries and object references.
+func bplistReadUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// bplistParseIntAt decodes the integer object (marker 0x1_) at offset and
+// returns its value along with the offset of the byte following it.
+func bplistParseIntAt(src bplistSource, offset int) (value int64, next int, err error) {
+	m, err := src.readAt(offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if m[0]>>4 != 0x1 {
+		return 0, 0, fmt.Errorf("bplist: expected integer object at offset %d, got marker %#x", offset, m[0])
+	}
+	nbytes := 1 << (m[0] & 0x0f)
+	raw, err := src.readAt(offset+1, nbytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bplist: integer object runs past end of data")
+	}
+	// 64-bit integers are stored signed (used for negative values); the
+	// cast below reinterprets the top bit accordingly.
+	return int64(bplistReadUint(raw)), offset + 1 + nbytes, nil
+}
+
+// bplistLength reads the length nibble of an object marker at offset,
+// following the 0xf "length follows as an int object" escape when present,
+// and returns the length along with the offset of the object's payload.
+func bplistLength(src bplistSource, offset int) (n int, start int, err error) {
+	m, err := src.readAt(offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	lo := m[0] & 0x0f
+	if lo != 0x0f {
+		return int(lo), offset + 1, nil
+	}
+	v, next, err := bplistParseIntAt(src, offset+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(v), next, nil
+}
+
+// bplistReadObject decodes the object stored at offsets[index], recursing
+// into arrays, sets, and dicts via their objectRefSize-wide references.
+func bplistReadObject(src bplistSource, offsets []uint64, refSize int, index uint64) (interface{}, error) {
+	if index >= uint64(len(offsets)) {
+		return nil, fmt.Errorf("bplist: object reference %d out of range", index)
+	}
+	offset := int(offsets[index])
+	m, err := src.readAt(offset, 1)
+	if err != nil {
+		return nil, fmt.Errorf("bplist: object offset %d out of range", offset)
+	}
+	marker := m[0]
+	switch marker >> 4 {
+	case 0x0:
+		switch marker {
+		case 0x00, 0x0f:
+			return nil, nil
+		case 0x08:
+			return false, nil
+		case 0x09:
+			return true, nil
+		}
+		return nil, fmt.Errorf("bplist: unknown marker %#x", marker)
+
+	case 0x1:
+		v, _, err := bplistParseIntAt(src, offset)
+		return v, err
+
+	case 0x2:
+		nbytes := 1 << (marker & 0x0f)
+		raw, err := src.readAt(offset+1, nbytes)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: real object runs past end of data")
+		}
+		if nbytes == 4 {
+			return float64(math.Float32frombits(uint32(bplistReadUint(raw)))), nil
+		}
+		return math.Float64frombits(bplistReadUint(raw)), nil
+
+	case 0x3:
+		raw, err := src.readAt(offset+1, 8)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: date object runs past end of data")
+		}
+		secs := math.Float64frombits(bplistReadUint(raw))
+		return bplistEpoch.Add(time.Duration(secs * float64(time.Second))), nil
+
+	case 0x4:
+		n, start, err := bplistLength(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := src.readAt(start, n)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: data object runs past end of data")
+		}
+		return append([]byte(nil), raw...), nil
+
+	case 0x5:
+		n, start, err := bplistLength(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := src.readAt(start, n)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: string object runs past end of data")
+		}
+		return string(raw), nil
+
+	case 0x6:
+		n, start, err := bplistLength(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := src.readAt(start, n*2)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: string object runs past end of data")
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = uint16(bplistReadUint(raw[i*2 : i*2+2]))
+		}
+		return string(utf16.Decode(units)), nil
+
+	case 0x8:
+		nbytes := int(marker&0x0f) + 1
+		raw, err := src.readAt(offset+1, nbytes)
+		if err != nil {
+			return nil, fmt.Errorf("bplist: UID object runs past end of data")
+		}
+		return UID(bplistReadUint(raw)), nil
+
+	case 0xa, 0xc:
+		n, start, err := bplistLength(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			refRaw, err := src.readAt(start+i*refSize, refSize)
+			if err != nil {
+				return nil, fmt.Errorf("bplist: array refs run past end of data")
+			}
+			result[i], err = bplistReadObject(src, offsets, refSize, bplistReadUint(refRaw))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+
+	case 0xd:
+		n, start, err := bplistLength(src, offset)
+		if err != nil {
+			return nil, err
+		}
+		d := &bplistDict{keys: make([]string, n), vals: make([]interface{}, n)}
+		valStart := start + n*refSize
+		for i := 0; i < n; i++ {
+			keyRaw, err := src.readAt(start+i*refSize, refSize)
+			if err != nil {
+				return nil, fmt.Errorf("bplist: dict refs run past end of data")
+			}
+			key, err := bplistReadObject(src, offsets, refSize, bplistReadUint(keyRaw))
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("bplist: dict key is not a string: %#v", key)
+			}
+			d.keys[i] = name
+
+			valRaw, err := src.readAt(valStart+i*refSize, refSize)
+			if err != nil {
+				return nil, fmt.Errorf("bplist: dict refs run past end of data")
+			}
+			d.vals[i], err = bplistReadObject(src, offsets, refSize, bplistReadUint(valRaw))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("bplist: unsupported marker %#x", marker)
+}
+
+// assignPlistValue assigns a decoded generic plist object (produced by
+// bplistReadObject or a text_parser.go parse) into v, following the same
+// field-matching rules as unmarshalValue's <dict>/<array> cases.
+func assignPlistValue(obj interface{}, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch o := obj.(type) {
+	case nil:
+		return nil
+
+	case *bplistDict:
+		if v.Kind() == reflect.Interface {
+			m := reflect.New(reflect.TypeOf(map[string]interface{}{})).Elem()
+			if err := assignPlistValue(o, m); err != nil {
+				return err
+			}
+			v.Set(m)
+			return nil
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			t := v.Type()
+			for i, name := range o.keys {
+				if fi, ok := plistFieldByName(t, name); ok {
+					if err := assignPlistValue(o.vals[i], v.Field(fi)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		case reflect.Map:
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+			t := v.Type()
+			for i, name := range o.keys {
+				elem := reflect.New(t.Elem()).Elem()
+				if err := assignPlistValue(o.vals[i], elem); err != nil {
+					return err
+				}
+				v.SetMapIndex(reflect.ValueOf(name), elem)
+			}
+			return nil
+		}
+		return fmt.Errorf("cannot unmarshal dict into non-struct/map %s", v.Type())
+
+	case []interface{}:
+		if v.Kind() == reflect.Interface {
+			s := reflect.New(reflect.TypeOf([]interface{}{})).Elem()
+			if err := assignPlistValue(o, s); err != nil {
+				return err
+			}
+			v.Set(s)
+			return nil
+		}
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("cannot unmarshal array into non-slice %s", v.Type())
+		}
+		t := v.Type()
+		s := reflect.MakeSlice(t, 0, len(o))
+		for _, e := range o {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := assignPlistValue(e, elem); err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+		v.Set(s)
+		return nil
+
+	case string:
+		return setString(v, o)
+
+	case int64:
+		return setInt(v, o)
+
+	case float64:
+		return setFloat(v, o)
+
+	case time.Time:
+		return setTime(v, o)
+
+	case []byte:
+		return setBytes(v, o)
+
+	case bool:
+		return setBool(v, o)
+
+	case UID:
+		if v.Kind() == reflect.Interface {
+			v.Set(reflect.ValueOf(o))
+			return nil
+		}
+		return setInt(v, int64(o))
+	}
+	return fmt.Errorf("bplist: unsupported object type %T", obj)
+}
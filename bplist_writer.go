@@ -0,0 +1,270 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// bplistArrayRefs is the writer's encoding of an array/set object: indices
+// into bplistWriter.objects for each element.
+type bplistArrayRefs []int
+
+// bplistDictRefs is the writer's encoding of a dict object: parallel
+// index slices into bplistWriter.objects for keys and values.
+type bplistDictRefs struct {
+	keys []int
+	vals []int
+}
+
+// bplistWriter builds the deduplicated object table a binary plist is
+// serialized from: identical strings, integers, and data share a single
+// entry, which matters for keyed archives.
+type bplistWriter struct {
+	objects []interface{}
+	index   map[string]int
+}
+
+// add records obj (the generic representation marshalValue produces) in
+// the object table, reusing an existing entry for identical scalars, and
+// returns its index.
+func (w *bplistWriter) add(obj interface{}) (int, error) {
+	switch o := obj.(type) {
+	case nil:
+		return w.addScalar("null:", nil), nil
+	case bool:
+		return w.addScalar(fmt.Sprintf("bool:%v", o), o), nil
+	case int64:
+		return w.addScalar(fmt.Sprintf("int:%d", o), o), nil
+	case float64:
+		return w.addScalar(fmt.Sprintf("real:%x", o), o), nil
+	case time.Time:
+		return w.addScalar(fmt.Sprintf("date:%d", o.UnixNano()), o), nil
+	case []byte:
+		return w.addScalar("data:"+string(o), append([]byte(nil), o...)), nil
+	case string:
+		return w.addScalar("string:"+o, o), nil
+	case []interface{}:
+		refs := make([]int, len(o))
+		for i, e := range o {
+			idx, err := w.add(e)
+			if err != nil {
+				return 0, err
+			}
+			refs[i] = idx
+		}
+		idx := len(w.objects)
+		w.objects = append(w.objects, bplistArrayRefs(refs))
+		return idx, nil
+	case *bplistDict:
+		keys := make([]int, len(o.keys))
+		vals := make([]int, len(o.vals))
+		for i, k := range o.keys {
+			idx, err := w.add(k)
+			if err != nil {
+				return 0, err
+			}
+			keys[i] = idx
+		}
+		for i, v := range o.vals {
+			idx, err := w.add(v)
+			if err != nil {
+				return 0, err
+			}
+			vals[i] = idx
+		}
+		idx := len(w.objects)
+		w.objects = append(w.objects, bplistDictRefs{keys: keys, vals: vals})
+		return idx, nil
+	}
+	return 0, fmt.Errorf("plist: cannot encode %T as binary plist object", obj)
+}
+
+func (w *bplistWriter) addScalar(key string, val interface{}) int {
+	if w.index == nil {
+		w.index = make(map[string]int)
+	}
+	if idx, ok := w.index[key]; ok {
+		return idx
+	}
+	idx := len(w.objects)
+	w.objects = append(w.objects, val)
+	w.index[key] = idx
+	return idx
+}
+
+// writeBinary serializes obj (the generic representation marshalValue
+// produces) as a bplist00 document.
+func writeBinary(w io.Writer, obj interface{}) error {
+	bw := &bplistWriter{}
+	topObject, err := bw.add(obj)
+	if err != nil {
+		return err
+	}
+
+	refSize := bplistIntWidth(uint64(len(bw.objects)))
+
+	var objectsBuf bytes.Buffer
+	offsets := make([]uint64, len(bw.objects))
+	for i, o := range bw.objects {
+		offsets[i] = uint64(len(bplistMagic)) + uint64(objectsBuf.Len())
+		if err := writeBplistObject(&objectsBuf, o, refSize); err != nil {
+			return err
+		}
+	}
+
+	offsetTableOffset := uint64(len(bplistMagic)) + uint64(objectsBuf.Len())
+	offsetIntSize := bplistIntWidth(offsetTableOffset)
+
+	var out bytes.Buffer
+	out.Write(bplistMagic)
+	out.Write(objectsBuf.Bytes())
+	for _, off := range offsets {
+		writeBplistUint(&out, off, offsetIntSize)
+	}
+
+	var trailer [32]byte
+	trailer[6] = byte(offsetIntSize)
+	trailer[7] = byte(refSize)
+	binary.BigEndian.PutUint64(trailer[8:16], uint64(len(bw.objects)))
+	binary.BigEndian.PutUint64(trailer[16:24], uint64(topObject))
+	binary.BigEndian.PutUint64(trailer[24:32], offsetTableOffset)
+	out.Write(trailer[:])
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// bplistIntWidth returns the smallest of 1, 2, 4, or 8 bytes that can
+// hold n, the same widths offsetIntSize and objectRefSize are drawn from.
+func bplistIntWidth(n uint64) int {
+	switch {
+	case n < 1<<8:
+		return 1
+	case n < 1<<16:
+		return 2
+	case n < 1<<32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+func writeBplistUint(buf *bytes.Buffer, v uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[8-size:])
+}
+
+// writeBplistInt writes v as the smallest integer object marker (0x1_)
+// that can hold it. Negative values always use the 8-byte width: the
+// reader zero-extends the 1/2/4-byte widths (they only ever hold lengths
+// and small positive integers in practice), so, matching Apple's
+// CFBinaryPlist, a negative value is only ever stored 8 bytes wide.
+func writeBplistInt(buf *bytes.Buffer, v int64) {
+	var n int
+	switch {
+	case v < 0:
+		n = 8
+	case v < 1<<7:
+		n = 1
+	case v < 1<<15:
+		n = 2
+	case v < 1<<31:
+		n = 4
+	default:
+		n = 8
+	}
+	lo := byte(0)
+	for s := n; s > 1; s >>= 1 {
+		lo++
+	}
+	buf.WriteByte(0x10 | lo)
+	writeBplistUint(buf, uint64(v), n)
+}
+
+// writeBplistLength writes a marker byte combining hi with n, using the
+// 0xf "length follows as an int object" escape for n >= 0x0f.
+func writeBplistLength(buf *bytes.Buffer, hi byte, n int) {
+	if n < 0x0f {
+		buf.WriteByte(hi<<4 | byte(n))
+		return
+	}
+	buf.WriteByte(hi<<4 | 0x0f)
+	writeBplistInt(buf, int64(n))
+}
+
+func writeBplistObject(buf *bytes.Buffer, obj interface{}, refSize int) error {
+	switch o := obj.(type) {
+	case nil:
+		buf.WriteByte(0x00)
+	case bool:
+		if o {
+			buf.WriteByte(0x09)
+		} else {
+			buf.WriteByte(0x08)
+		}
+	case int64:
+		writeBplistInt(buf, o)
+	case float64:
+		buf.WriteByte(0x23)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(o))
+		buf.Write(b[:])
+	case time.Time:
+		buf.WriteByte(0x33)
+		secs := o.Sub(bplistEpoch).Seconds()
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(secs))
+		buf.Write(b[:])
+	case []byte:
+		writeBplistLength(buf, 0x4, len(o))
+		buf.Write(o)
+	case string:
+		if isASCII(o) {
+			writeBplistLength(buf, 0x5, len(o))
+			buf.WriteString(o)
+		} else {
+			units := utf16.Encode([]rune(o))
+			writeBplistLength(buf, 0x6, len(units))
+			for _, u := range units {
+				var b [2]byte
+				binary.BigEndian.PutUint16(b[:], u)
+				buf.Write(b[:])
+			}
+		}
+	case bplistArrayRefs:
+		writeBplistLength(buf, 0xa, len(o))
+		for _, ref := range o {
+			writeBplistUint(buf, uint64(ref), refSize)
+		}
+	case bplistDictRefs:
+		writeBplistLength(buf, 0xd, len(o.keys))
+		for _, ref := range o.keys {
+			writeBplistUint(buf, uint64(ref), refSize)
+		}
+		for _, ref := range o.vals {
+			writeBplistUint(buf, uint64(ref), refSize)
+		}
+	default:
+		return fmt.Errorf("plist: cannot write %T as binary plist object", obj)
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 127 {
+			return false
+		}
+	}
+	return true
+}
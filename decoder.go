@@ -0,0 +1,380 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// Token is implemented by each event a Decoder's Token method can return:
+// StartDict, EndDict, StartArray, EndArray, Key, String, Integer, Real,
+// Date, Data, and Bool.
+type Token interface{}
+
+// StartDict and EndDict bracket the key/value pairs of a <dict>.
+type StartDict struct{}
+type EndDict struct{}
+
+// StartArray and EndArray bracket the elements of an <array>.
+type StartArray struct{}
+type EndArray struct{}
+
+// Key is a <dict> entry's key, always immediately followed by its value
+// token (or a StartDict/StartArray beginning it).
+type Key string
+
+// String, Integer, Real, Date, Data, and Bool are leaf value tokens.
+type String string
+type Integer int64
+type Real float64
+type Date time.Time
+type Data []byte
+type Bool bool
+
+// sizedReaderAt is satisfied by readers, such as bytes.Reader and
+// strings.Reader, that can report their total length. When a Decoder's
+// underlying reader implements it, binary plists are decoded by seeking
+// through the offset table instead of buffering the whole document.
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// Decoder reads a plist from an io.Reader, either as a single decoded
+// value via Decode or as a stream of Tokens.
+type Decoder struct {
+	r  io.Reader
+	br *bufio.Reader
+
+	buf  []byte // growable window of bytes read from br but not yet consumed
+	full bool   // true once buf holds everything remaining in br
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, br: bufio.NewReader(r)}
+}
+
+// grow reads another chunk from the underlying reader into buf.
+func (d *Decoder) grow() error {
+	if d.full {
+		return nil
+	}
+	chunk := make([]byte, 4096)
+	n, err := d.br.Read(chunk)
+	if n > 0 {
+		d.buf = append(d.buf, chunk[:n]...)
+	}
+	if err != nil {
+		if err == io.EOF {
+			d.full = true
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// peek ensures at least n bytes are buffered, growing the window as
+// needed, and returns however many are available (fewer than n at EOF).
+func (d *Decoder) peek(n int) ([]byte, error) {
+	for len(d.buf) < n && !d.full {
+		if err := d.grow(); err != nil {
+			return nil, err
+		}
+	}
+	if len(d.buf) > n {
+		return d.buf[:n], nil
+	}
+	return d.buf, nil
+}
+
+// readAll buffers and returns everything remaining in the reader.
+func (d *Decoder) readAll() ([]byte, error) {
+	for !d.full {
+		if err := d.grow(); err != nil {
+			return nil, err
+		}
+	}
+	return d.buf, nil
+}
+
+// readTag is next's streaming counterpart: it grows buf just enough to
+// find the next '<'...'>' tag, returning any text preceding it, and then
+// slides the window forward past what it consumed.
+func (d *Decoder) readTag() (content, tag []byte, err error) {
+	for {
+		i := bytes.IndexByte(d.buf, '<')
+		if i < 0 {
+			if d.full {
+				content = d.buf
+				d.buf = nil
+				return content, nil, io.EOF
+			}
+			if err := d.grow(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		j := bytes.IndexByte(d.buf[i:], '>')
+		if j < 0 {
+			if d.full {
+				return nil, nil, fmt.Errorf("plist: unterminated tag")
+			}
+			if err := d.grow(); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		j += i + 1
+		content = append([]byte(nil), d.buf[:i]...)
+		tag = append([]byte(nil), d.buf[i:j]...)
+		d.buf = d.buf[j:]
+		return content, tag, nil
+	}
+}
+
+// Token returns the next parsing event in the XML plist being read.
+func (d *Decoder) Token() (Token, error) {
+	_, tag, err := d.readTag()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(tag, []byte("<?xml")), bytes.HasPrefix(tag, []byte("<!DOCTYPE")), bytes.HasPrefix(tag, []byte("<plist")):
+		return d.Token()
+	}
+	switch string(tag) {
+	case "<dict>":
+		return StartDict{}, nil
+	case "</dict>":
+		return EndDict{}, nil
+	case "<array>":
+		return StartArray{}, nil
+	case "</array>":
+		return EndArray{}, nil
+	case "</plist>":
+		return nil, io.EOF
+	case "<key>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</key>" {
+			return nil, fmt.Errorf("plist: expected </key> but got %s", etag)
+		}
+		return Key(body), nil
+	case "<string>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</string>" {
+			return nil, fmt.Errorf("plist: expected </string> but got %s", etag)
+		}
+		s, err := unescapePlistString(body)
+		if err != nil {
+			return nil, err
+		}
+		return String(s), nil
+	case "<integer>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</integer>" {
+			return nil, fmt.Errorf("plist: expected </integer> but got %s", etag)
+		}
+		i, err := strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: non-integer in <integer> tag: %s", body)
+		}
+		return Integer(i), nil
+	case "<real>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</real>" {
+			return nil, fmt.Errorf("plist: expected </real> but got %s", etag)
+		}
+		f, err := strconv.ParseFloat(string(body), 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: non-float in <real> tag: %s", body)
+		}
+		return Real(f), nil
+	case "<date>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</date>" {
+			return nil, fmt.Errorf("plist: expected </date> but got %s", etag)
+		}
+		t, err := time.Parse(time.RFC3339, string(body))
+		if err != nil {
+			return nil, fmt.Errorf("plist: non-date in <date> tag: %s", body)
+		}
+		return Date(t), nil
+	case "<data>":
+		body, etag, err := d.readTag()
+		if err != nil {
+			return nil, err
+		}
+		if string(etag) != "</data>" {
+			return nil, fmt.Errorf("plist: expected </data> but got %s", etag)
+		}
+		b, err := decodeBase64(body)
+		if err != nil {
+			return nil, fmt.Errorf("plist: non-base64 in <data> tag: %s", body)
+		}
+		return Data(b), nil
+	case "<true/>":
+		return Bool(true), nil
+	case "<false/>":
+		return Bool(false), nil
+	}
+	return nil, fmt.Errorf("plist: unexpected tag %s", tag)
+}
+
+// Decode reads an entire plist document from the underlying reader into
+// v. Binary plists are read via seeking when the reader implements
+// sizedReaderAt; XML plists are read incrementally via Token, without
+// ever buffering more than one open element's worth of data at a time.
+// OpenStep/ASCII plists, which this package parses with a single-pass
+// recursive-descent parser, are buffered in full.
+func (d *Decoder) Decode(v interface{}) error {
+	magic, err := d.peek(len(bplistMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if bytes.Equal(magic, bplistMagic) {
+		return d.decodeBinary(reflect.ValueOf(v))
+	}
+
+	probe, err := d.peek(64)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if trimmed := bytes.TrimLeft(probe, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '<' {
+		all, err := d.readAll()
+		if err != nil {
+			return err
+		}
+		return unmarshalText(all, reflect.ValueOf(v))
+	}
+
+	tok, err := d.Token()
+	if err != nil {
+		return err
+	}
+	obj, err := d.treeFromToken(tok)
+	if err != nil {
+		return err
+	}
+	return assignPlistValue(obj, reflect.ValueOf(v))
+}
+
+// treeFromToken consumes whatever further tokens are needed to complete
+// the value tok begins, building the same generic representation
+// bplistReadObject and the text parser produce.
+func (d *Decoder) treeFromToken(tok Token) (interface{}, error) {
+	switch t := tok.(type) {
+	case StartDict:
+		dict := &bplistDict{}
+		for {
+			kt, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := kt.(EndDict); ok {
+				return dict, nil
+			}
+			key, ok := kt.(Key)
+			if !ok {
+				return nil, fmt.Errorf("plist: expected key inside <dict>, got %T", kt)
+			}
+			vt, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.treeFromToken(vt)
+			if err != nil {
+				return nil, err
+			}
+			dict.keys = append(dict.keys, string(key))
+			dict.vals = append(dict.vals, val)
+		}
+	case StartArray:
+		arr := []interface{}{}
+		for {
+			et, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := et.(EndArray); ok {
+				return arr, nil
+			}
+			val, err := d.treeFromToken(et)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+	case String:
+		return string(t), nil
+	case Integer:
+		return int64(t), nil
+	case Real:
+		return float64(t), nil
+	case Date:
+		return time.Time(t), nil
+	case Data:
+		return []byte(t), nil
+	case Bool:
+		return bool(t), nil
+	}
+	return nil, fmt.Errorf("plist: unexpected token %T", tok)
+}
+
+// decodeBinary decodes a bplist00 document, seeking through the offset
+// table via the underlying reader when possible instead of buffering it.
+func (d *Decoder) decodeBinary(v reflect.Value) error {
+	if ra, ok := d.r.(sizedReaderAt); ok && !d.full {
+		return decodeBplist(&bplistReaderAtSource{r: ra, n: ra.Size()}, v)
+	}
+	all, err := d.readAll()
+	if err != nil {
+		return err
+	}
+	return decodeBplist(bplistSliceSource(all), v)
+}
+
+// bplistReaderAtSource is a bplistSource that reads each object on demand
+// via ReadAt, so decoding a large binary plist need not hold the whole
+// file in memory at once.
+type bplistReaderAtSource struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (s *bplistReaderAtSource) readAt(off, n int) ([]byte, error) {
+	if off < 0 || n < 0 || int64(off+n) > s.n {
+		return nil, fmt.Errorf("bplist: read past end of data")
+	}
+	buf := make([]byte, n)
+	if _, err := s.r.ReadAt(buf, int64(off)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *bplistReaderAtSource) size() int { return int(s.n) }
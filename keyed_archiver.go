@@ -0,0 +1,402 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// classRegistry maps an Objective-C class name, as it appears in a
+// keyed archive's $class entries, to the Go type UnmarshalKeyedArchive
+// builds for it.
+var classRegistry = make(map[string]reflect.Type)
+
+// RegisterClass associates the Objective-C class name used in an
+// NSKeyedArchiver plist's $class entries with a Go struct type, so
+// UnmarshalKeyedArchive can build one in place of a generic map when it
+// encounters an object archived as that class.
+func RegisterClass(name string, t reflect.Type) {
+	classRegistry[name] = t
+}
+
+// UnmarshalKeyedArchive decodes data, an NSKeyedArchiver-format plist (as
+// produced by NSKeyedArchiver and consumed by common Apple artifacts such
+// as .SFL2 sidebar files, NSUbiquitousKeyValueStore snapshots, and
+// shortcut files), resolving CF$UID references and rebuilding the
+// archived object graph into v. Classes registered with RegisterClass
+// decode into their Go type; everything else decodes into
+// map[string]interface{}, []interface{}, or a scalar. Values stored into
+// interface{} destinations, including the elements of those maps and
+// slices, keep the parser's scalar types (int64 for integers), unlike
+// Unmarshal, which stores a plain int.
+func UnmarshalKeyedArchive(data []byte, v interface{}) error {
+	tree, err := parsePlistTree(data)
+	if err != nil {
+		return err
+	}
+	root, ok := tree.(*bplistDict)
+	if !ok {
+		return fmt.Errorf("cfarchive: not a plist dict")
+	}
+	if archiver, _ := dictGet(root, "$archiver"); archiver != "NSKeyedArchiver" {
+		return fmt.Errorf("cfarchive: not an NSKeyedArchiver plist")
+	}
+	objectsVal, ok := dictGet(root, "$objects")
+	objects, ok2 := objectsVal.([]interface{})
+	if !ok || !ok2 {
+		return fmt.Errorf("cfarchive: missing $objects")
+	}
+	topVal, ok := dictGet(root, "$top")
+	top, ok2 := topVal.(*bplistDict)
+	if !ok || !ok2 {
+		return fmt.Errorf("cfarchive: missing $top")
+	}
+
+	rootRef, ok := dictGet(top, "root")
+	if !ok {
+		if len(top.vals) == 0 {
+			return fmt.Errorf("cfarchive: $top has no entries")
+		}
+		rootRef = top.vals[0]
+	}
+	rootIdx, ok := asUID(rootRef)
+	if !ok {
+		return fmt.Errorf("cfarchive: $top root is not a CF$UID reference")
+	}
+
+	r := &archiveResolver{objects: objects, cache: make(map[int]interface{})}
+	obj, err := r.resolve(rootIdx)
+	if err != nil {
+		return err
+	}
+	return assignArchiveValue(obj, reflect.ValueOf(v))
+}
+
+// parsePlistTree decodes data into the generic representation shared by
+// the binary, text, and XML decoders, without assigning it into any
+// particular Go value. UnmarshalKeyedArchive needs this to walk
+// $objects, $top, and $class itself before any type-directed assignment
+// happens.
+func parsePlistTree(data []byte) (interface{}, error) {
+	if bytes.HasPrefix(data, bplistMagic) {
+		return bplistTree(bplistSliceSource(data))
+	}
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '<' {
+		p := &textParser{data: data}
+		p.skipSpace()
+		return p.parseValue()
+	}
+	dec := NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	return dec.treeFromToken(tok)
+}
+
+// dictGet looks up key in d, returning its value and whether it was
+// present.
+func dictGet(d *bplistDict, key string) (interface{}, bool) {
+	for i, k := range d.keys {
+		if k == key {
+			return d.vals[i], true
+		}
+	}
+	return nil, false
+}
+
+// asUID reports whether val is a CF$UID reference, as either a binary
+// plist UID object or the one-key {CF$UID = N;} dict the XML and
+// OpenStep formats encode it as, and returns the index it refers to.
+func asUID(val interface{}) (int, bool) {
+	switch t := val.(type) {
+	case UID:
+		return int(t), true
+	case *bplistDict:
+		if len(t.keys) == 1 && t.keys[0] == "CF$UID" {
+			if n, ok := t.vals[0].(int64); ok {
+				return int(n), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// archiveResolver walks an NSKeyedArchiver $objects table, resolving
+// CF$UID references and $class lookups into plain Go values, registered
+// struct types, or generic maps. Resolved entries are cached by object
+// index both to avoid re-resolving shared objects and to let cyclic
+// object graphs (a registered class whose fields reference an ancestor)
+// resolve correctly: a class's pointer is cached before its fields are
+// populated, so a cycle back to it reuses the same pointer.
+type archiveResolver struct {
+	objects []interface{}
+	cache   map[int]interface{}
+}
+
+// resolve returns the Go value archived at objects[idx].
+func (r *archiveResolver) resolve(idx int) (interface{}, error) {
+	if v, ok := r.cache[idx]; ok {
+		return v, nil
+	}
+	if idx < 0 || idx >= len(r.objects) {
+		return nil, fmt.Errorf("cfarchive: object reference %d out of range", idx)
+	}
+	raw := r.objects[idx]
+	if s, ok := raw.(string); ok && s == "$null" {
+		return nil, nil
+	}
+	d, ok := raw.(*bplistDict)
+	if !ok {
+		return raw, nil
+	}
+	classRef, ok := dictGet(d, "$class")
+	if !ok {
+		return d, nil
+	}
+	classIdx, ok := asUID(classRef)
+	if !ok {
+		return nil, fmt.Errorf("cfarchive: $class is not a CF$UID reference")
+	}
+	className, err := r.className(classIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch className {
+	case "NSArray", "NSMutableArray", "NSSet", "NSMutableSet", "NSOrderedSet", "NSMutableOrderedSet":
+		return r.resolveArray(idx, d)
+	case "NSDictionary", "NSMutableDictionary":
+		return r.resolveDict(idx, d)
+	case "NSString", "NSMutableString":
+		s, _ := dictGet(d, "NS.string")
+		r.cache[idx] = s
+		return s, nil
+	case "NSData", "NSMutableData":
+		b, _ := dictGet(d, "NS.data")
+		r.cache[idx] = b
+		return b, nil
+	case "NSDate":
+		secs, _ := dictGet(d, "NS.time")
+		f, _ := secs.(float64)
+		t := bplistEpoch.Add(time.Duration(f * float64(time.Second)))
+		r.cache[idx] = t
+		return t, nil
+	}
+
+	if t, ok := classRegistry[className]; ok {
+		ptr := reflect.New(t)
+		r.cache[idx] = ptr.Interface()
+		if err := r.populateStruct(ptr.Elem(), d); err != nil {
+			return nil, err
+		}
+		return ptr.Interface(), nil
+	}
+	return r.resolveGenericDict(idx, d)
+}
+
+func (r *archiveResolver) resolveArray(idx int, d *bplistDict) (interface{}, error) {
+	elemsVal, _ := dictGet(d, "NS.objects")
+	elems, _ := elemsVal.([]interface{})
+	result := make([]interface{}, len(elems))
+	r.cache[idx] = result
+	for i, e := range elems {
+		eidx, ok := asUID(e)
+		if !ok {
+			return nil, fmt.Errorf("cfarchive: NS.objects entry is not a CF$UID reference")
+		}
+		v, err := r.resolve(eidx)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+func (r *archiveResolver) resolveDict(idx int, d *bplistDict) (interface{}, error) {
+	keysVal, _ := dictGet(d, "NS.keys")
+	valsVal, _ := dictGet(d, "NS.objects")
+	keys, _ := keysVal.([]interface{})
+	vals, _ := valsVal.([]interface{})
+	result := make(map[string]interface{}, len(keys))
+	r.cache[idx] = result
+	for i := range keys {
+		kidx, ok := asUID(keys[i])
+		if !ok {
+			return nil, fmt.Errorf("cfarchive: NS.keys entry is not a CF$UID reference")
+		}
+		kv, err := r.resolve(kidx)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := kv.(string)
+		if !ok {
+			return nil, fmt.Errorf("cfarchive: dict key resolved to non-string %T", kv)
+		}
+		if i >= len(vals) {
+			return nil, fmt.Errorf("cfarchive: NS.keys/NS.objects length mismatch")
+		}
+		vidx, ok := asUID(vals[i])
+		if !ok {
+			return nil, fmt.Errorf("cfarchive: NS.objects entry is not a CF$UID reference")
+		}
+		vv, err := r.resolve(vidx)
+		if err != nil {
+			return nil, err
+		}
+		result[key] = vv
+	}
+	return result, nil
+}
+
+// resolveGenericDict resolves an archived object of an unregistered
+// class into a map[string]interface{} keyed by its archived property
+// names, the way a struct without a registered type decodes for plain
+// Unmarshal.
+func (r *archiveResolver) resolveGenericDict(idx int, d *bplistDict) (interface{}, error) {
+	result := make(map[string]interface{}, len(d.keys))
+	r.cache[idx] = result
+	for i, key := range d.keys {
+		if key == "$class" {
+			continue
+		}
+		v, err := r.resolveField(d.vals[i])
+		if err != nil {
+			return nil, err
+		}
+		result[key] = v
+	}
+	return result, nil
+}
+
+func (r *archiveResolver) populateStruct(v reflect.Value, d *bplistDict) error {
+	t := v.Type()
+	for i, key := range d.keys {
+		if key == "$class" {
+			continue
+		}
+		fi, ok := plistFieldByName(t, key)
+		if !ok {
+			continue
+		}
+		val, err := r.resolveField(d.vals[i])
+		if err != nil {
+			return err
+		}
+		if err := assignArchiveValue(val, v.Field(fi)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveField resolves val, a raw field value from an archived dict,
+// following it if it is a CF$UID reference and returning it unchanged
+// otherwise (archived scalars are stored inline, not as references).
+func (r *archiveResolver) resolveField(val interface{}) (interface{}, error) {
+	if idx, ok := asUID(val); ok {
+		return r.resolve(idx)
+	}
+	return val, nil
+}
+
+// className looks up the $classname of the class-description dict at
+// objects[idx].
+func (r *archiveResolver) className(idx int) (string, error) {
+	if idx < 0 || idx >= len(r.objects) {
+		return "", fmt.Errorf("cfarchive: class reference %d out of range", idx)
+	}
+	cd, ok := r.objects[idx].(*bplistDict)
+	if !ok {
+		return "", fmt.Errorf("cfarchive: $class entry is not a dict")
+	}
+	name, ok := dictGet(cd, "$classname")
+	s, ok2 := name.(string)
+	if !ok || !ok2 {
+		return "", fmt.Errorf("cfarchive: $class entry missing $classname")
+	}
+	return s, nil
+}
+
+// assignArchiveValue assigns val, a value built by archiveResolver, into
+// v. It extends assignPlistValue with the map[string]interface{} and
+// []interface{} shapes archiveResolver produces for collections, and
+// with already-built pointers to registered classes.
+func assignArchiveValue(val interface{}, v reflect.Value) error {
+	if rv := reflect.ValueOf(val); val != nil && rv.Kind() == reflect.Ptr {
+		// archiveResolver.resolve returns a *T for a registered class; T
+		// may be the destination's own type (a Struct-kind field or the
+		// top-level target) rather than a pointer to it.
+		if rv.Type().AssignableTo(v.Type()) {
+			if v.CanSet() {
+				v.Set(rv)
+				return nil
+			}
+			// v is the caller's top-level target, e.g. reflect.ValueOf(&x):
+			// not itself settable, but its pointee is, so copy into that.
+			v.Elem().Set(rv.Elem())
+			return nil
+		}
+		if rv.Type().Elem().AssignableTo(v.Type()) {
+			v.Set(rv.Elem())
+			return nil
+		}
+	}
+	if v.Kind() == reflect.Ptr {
+		if val == nil {
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assignArchiveValue(val, v.Elem())
+	}
+	if v.Kind() == reflect.Interface {
+		if val == nil {
+			return nil
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	switch o := val.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		if v.Kind() != reflect.Map {
+			return fmt.Errorf("cfarchive: cannot unmarshal dict into %s", v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for k, e := range o {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := assignArchiveValue(e, elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(k), elem)
+		}
+		return nil
+	case []interface{}:
+		if v.Kind() != reflect.Slice {
+			return fmt.Errorf("cfarchive: cannot unmarshal array into %s", v.Type())
+		}
+		s := reflect.MakeSlice(v.Type(), 0, len(o))
+		for _, e := range o {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := assignArchiveValue(e, elem); err != nil {
+				return err
+			}
+			s = reflect.Append(s, elem)
+		}
+		v.Set(s)
+		return nil
+	}
+	return assignPlistValue(val, v)
+}
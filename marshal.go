@@ -0,0 +1,421 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format identifies which on-disk plist representation an Encoder writes.
+type Format int
+
+const (
+	FormatXML Format = iota
+	FormatBinary
+	FormatOpenStep
+)
+
+// Encoder writes a plist to an output stream in one of the formats named
+// by Format.
+type Encoder struct {
+	w      io.Writer
+	format Format
+	indent string
+}
+
+// NewEncoder returns a new Encoder that writes to w. The default format is
+// FormatXML, indented with a single tab per nesting level.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, format: FormatXML, indent: "\t"}
+}
+
+// SetFormat sets the format Encode uses for subsequent calls.
+func (e *Encoder) SetFormat(format Format) {
+	e.format = format
+}
+
+// Indent sets the string used for one level of XML indentation. An empty
+// string produces compact output with no added whitespace. It has no
+// effect on FormatBinary.
+func (e *Encoder) Indent(indent string) {
+	e.indent = indent
+}
+
+// Encode writes v to the underlying writer in the Encoder's format.
+func (e *Encoder) Encode(v interface{}) error {
+	obj, err := marshalValue(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	switch e.format {
+	case FormatXML:
+		return writeXML(e.w, obj, e.indent)
+	case FormatBinary:
+		return writeBinary(e.w, obj)
+	case FormatOpenStep:
+		return writeOpenStep(e.w, obj)
+	}
+	return fmt.Errorf("plist: unknown format %d", e.format)
+}
+
+// Marshal returns the XML plist encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	return MarshalIndent(v, "")
+}
+
+// MarshalIndent is like Marshal but indents nested elements using indent
+// for each level, the way xml.MarshalIndent does for XML documents.
+func MarshalIndent(v interface{}, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.Indent(indent)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+var timeType = reflect.TypeOf(time.Time{})
+
+// marshalValue walks v, a struct/slice/scalar Go value of the kind
+// unmarshalValue and unmarshalBinary produce, into the same generic
+// representation bplistReadObject returns: nil, bool, int64, float64,
+// time.Time, []byte, string, []interface{}, or *bplistDict.
+func marshalValue(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	if v.IsValid() && v.Type() == timeType {
+		return v.Interface().(time.Time), nil
+	}
+	if v.IsValid() && v.CanInterface() && v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return string(text), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			return b, nil
+		}
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			elem, err := marshalValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			arr[i] = elem
+		}
+		return arr, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("plist: cannot marshal map with non-string key type %s", v.Type().Key())
+		}
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = k.String()
+		}
+		sort.Strings(names)
+		d := &bplistDict{}
+		for _, name := range names {
+			val, err := marshalValue(v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())))
+			if err != nil {
+				return nil, err
+			}
+			d.keys = append(d.keys, name)
+			d.vals = append(d.vals, val)
+		}
+		return d, nil
+	case reflect.Struct:
+		t := v.Type()
+		d := &bplistDict{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			name, omitempty := plistFieldName(f)
+			if name == "-" {
+				continue
+			}
+			fv := v.Field(i)
+			if omitempty && isEmptyValue(fv) {
+				continue
+			}
+			val, err := marshalValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			d.keys = append(d.keys, name)
+			d.vals = append(d.vals, val)
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("plist: cannot marshal %s", v.Type())
+}
+
+// plistFieldName parses a struct field's `plist:"name,omitempty"` tag,
+// falling back to the field's Go name.
+func plistFieldName(f reflect.StructField) (name string, omitempty bool) {
+	tag := f.Tag.Get("plist")
+	if tag == "" {
+		return f.Name, false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitempty = true
+		}
+	}
+	return name, omitempty
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// writeXML renders obj as a complete XML plist document.
+func writeXML(w io.Writer, obj interface{}, indent string) error {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>`)
+	buf.WriteString(xmlNewline(indent))
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">`)
+	buf.WriteString(xmlNewline(indent))
+	buf.WriteString(`<plist version="1.0">`)
+	writeXMLValue(&buf, obj, indent, 1)
+	buf.WriteString(xmlNewline(indent))
+	buf.WriteString(`</plist>`)
+	buf.WriteString(xmlNewline(indent))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func xmlNewline(indent string) string {
+	if indent == "" {
+		return ""
+	}
+	return "\n"
+}
+
+func xmlIndent(indent string, depth int) string {
+	if indent == "" {
+		return ""
+	}
+	return "\n" + strings.Repeat(indent, depth)
+}
+
+// writeXMLValue writes obj's tag(s), recursing into dicts and arrays with
+// one extra level of indentation.
+func writeXMLValue(buf *bytes.Buffer, obj interface{}, indent string, depth int) {
+	switch o := obj.(type) {
+	case nil:
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`<string></string>`)
+	case bool:
+		buf.WriteString(xmlIndent(indent, depth))
+		if o {
+			buf.WriteString(`<true/>`)
+		} else {
+			buf.WriteString(`<false/>`)
+		}
+	case int64:
+		fmt.Fprintf(buf, "%s<integer>%d</integer>", xmlIndent(indent, depth), o)
+	case float64:
+		fmt.Fprintf(buf, "%s<real>%s</real>", xmlIndent(indent, depth), formatXMLFloat(o))
+	case time.Time:
+		fmt.Fprintf(buf, "%s<date>%s</date>", xmlIndent(indent, depth), o.UTC().Format(time.RFC3339))
+	case []byte:
+		fmt.Fprintf(buf, "%s<data>%s</data>", xmlIndent(indent, depth), xmlBase64(o))
+	case string:
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`<string>`)
+		xml.EscapeText(buf, []byte(o))
+		buf.WriteString(`</string>`)
+	case []interface{}:
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`<array>`)
+		for _, e := range o {
+			writeXMLValue(buf, e, indent, depth+1)
+		}
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`</array>`)
+	case *bplistDict:
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`<dict>`)
+		for i, key := range o.keys {
+			buf.WriteString(xmlIndent(indent, depth+1))
+			buf.WriteString(`<key>`)
+			xml.EscapeText(buf, []byte(key))
+			buf.WriteString(`</key>`)
+			writeXMLValue(buf, o.vals[i], indent, depth+1)
+		}
+		buf.WriteString(xmlIndent(indent, depth))
+		buf.WriteString(`</dict>`)
+	}
+}
+
+func formatXMLFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func xmlBase64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// writeOpenStep renders obj as an OpenStep/ASCII (NeXT) plist document,
+// the format unmarshalText reads. Plain OpenStep has no native number,
+// boolean, or date type, so non-string scalars are written using the
+// GNUstep typed-scalar extension (<*I42>, <*R3.14>, <*BY>/<*BN>,
+// <*D...>) that textParser.parseAngle understands.
+func writeOpenStep(w io.Writer, obj interface{}) error {
+	var buf bytes.Buffer
+	writeOpenStepValue(&buf, obj)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeOpenStepValue(buf *bytes.Buffer, obj interface{}) {
+	switch o := obj.(type) {
+	case nil:
+		buf.WriteString(`""`)
+	case bool:
+		if o {
+			buf.WriteString(`<*BY>`)
+		} else {
+			buf.WriteString(`<*BN>`)
+		}
+	case int64:
+		fmt.Fprintf(buf, "<*I%d>", o)
+	case float64:
+		fmt.Fprintf(buf, "<*R%s>", formatXMLFloat(o))
+	case time.Time:
+		fmt.Fprintf(buf, "<*D%s>", o.UTC().Format("2006-01-02 15:04:05 -0700"))
+	case []byte:
+		buf.WriteByte('<')
+		for _, b := range o {
+			fmt.Fprintf(buf, "%02x", b)
+		}
+		buf.WriteByte('>')
+	case string:
+		writeOpenStepString(buf, o)
+	case []interface{}:
+		buf.WriteByte('(')
+		for i, e := range o {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeOpenStepValue(buf, e)
+		}
+		buf.WriteByte(')')
+	case *bplistDict:
+		buf.WriteByte('{')
+		for i, key := range o.keys {
+			writeOpenStepString(buf, key)
+			buf.WriteString(" = ")
+			writeOpenStepValue(buf, o.vals[i])
+			buf.WriteString("; ")
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// writeOpenStepString writes s unquoted when every character is valid in
+// an OpenStep bareword (matching isUnquotedStringChar, the same rule
+// textParser.parseUnquotedString uses), and as a quoted string with C-style
+// escapes otherwise.
+func writeOpenStepString(buf *bytes.Buffer, s string) {
+	if s != "" && isOpenStepBareword(s) {
+		buf.WriteString(s)
+		return
+	}
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 || r > 0x7e {
+				fmt.Fprintf(buf, "\\U%04x", r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func isOpenStepBareword(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isUnquotedStringChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,6 +29,13 @@ func next(data []byte) (skip, tag, rest []byte) {
 }
 
 func Unmarshal(data []byte, v interface{}) error {
+	if bytes.HasPrefix(data, bplistMagic) {
+		return unmarshalBinary(data, reflect.ValueOf(v))
+	}
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] != '<' {
+		return unmarshalText(data, reflect.ValueOf(v))
+	}
+
 	var tag []byte
 	for {
 		_, tag, data = next(data)
@@ -67,71 +75,10 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 
 	switch string(tag) {
 	case "<dict>":
-		t := v.Type()
-		if v.Kind() != reflect.Struct {
-			return nil, fmt.Errorf("cannot unmarshal <dict> into non-struct %s", v.Type())
-		}
-	Dict:
-		for {
-			_, tag, data = next(data)
-			if len(tag) == 0 {
-				return nil, fmt.Errorf("eof inside <dict>")
-			}
-			if string(tag) == "</dict>" {
-				break
-			}
-			if string(tag) != "<key>" {
-				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
-			}
-			var body []byte
-			body, tag, data = next(data)
-			if len(tag) == 0 {
-				return nil, fmt.Errorf("eof inside <dict>")
-			}
-			if string(tag) != "</key>" {
-				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
-			}
-			name := string(body)
-			var i int
-			for i = 0; i < t.NumField(); i++ {
-				f := t.Field(i)
-				if f.Name == name || f.Tag.Get("plist") == name {
-					data, err = unmarshalValue(data, v.Field(i))
-					if err != nil {
-						return nil, err
-					}
-					continue Dict
-				}
-			}
-			data, err = skipValue(data)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return data, nil
+		return unmarshalDict(data, v)
 
 	case "<array>":
-		t := v.Type()
-		if v.Kind() != reflect.Slice {
-			return nil, fmt.Errorf("cannot unmarshal <array> into non-slice %s", v.Type())
-		}
-		for {
-			_, tag, rest := next(data)
-			if len(tag) == 0 {
-				return nil, fmt.Errorf("eof inside <array>")
-			}
-			if string(tag) == "</array>" {
-				data = rest
-				break
-			}
-			elem := reflect.New(t.Elem()).Elem()
-			data, err = unmarshalValue(data, elem)
-			if err != nil {
-				return nil, err
-			}
-			v.Set(reflect.Append(v, elem))
-		}
-		return data, nil
+		return unmarshalArray(data, v)
 
 	case "<string>":
 		body, etag, data := next(data)
@@ -141,8 +88,13 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		if string(etag) != "</string>" {
 			return nil, fmt.Errorf("expected </string> but got %s", etag)
 		}
-		// TODO: unescape
-		v.Set(reflect.ValueOf(string(body)))
+		s, err := unescapePlistString(body)
+		if err != nil {
+			return nil, err
+		}
+		if err := setString(v, s); err != nil {
+			return nil, err
+		}
 		return data, nil
 
 	case "<integer>":
@@ -153,17 +105,15 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		if string(etag) != "</integer>" {
 			return nil, fmt.Errorf("expected </integer> but got %s", etag)
 		}
-		i, err := strconv.Atoi(string(body))
+		i, err := strconv.ParseInt(string(body), 10, 64)
 		if err != nil {
 			return nil, fmt.Errorf("non-integer in <integer> tag: %s", body)
 		}
-		v.Set(reflect.ValueOf(i))
+		if err := setInt(v, i); err != nil {
+			return nil, err
+		}
 		return data, nil
 	case "<real>":
-		bits := 64
-		if v.Kind() == reflect.Float32 {
-			bits = 32
-		}
 		body, etag, data := next(data)
 		if len(etag) == 0 {
 			return nil, fmt.Errorf("eof inside <real>")
@@ -171,11 +121,13 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		if string(etag) != "</real>" {
 			return nil, fmt.Errorf("expected </real> but got %s", etag)
 		}
-		f, err := strconv.ParseFloat(string(body), bits)
+		f, err := strconv.ParseFloat(string(body), 64)
 		if err != nil {
 			return nil, fmt.Errorf("non-float in <real> tag: %s", body)
 		}
-		v.Set(reflect.ValueOf(f))
+		if err := setFloat(v, f); err != nil {
+			return nil, err
+		}
 		return data, nil
 	case "<date>":
 		body, etag, data := next(data)
@@ -189,7 +141,9 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		if err != nil {
 			return nil, fmt.Errorf("non-date in <date> tag: %s", body)
 		}
-		v.Set(reflect.ValueOf(t))
+		if err := setTime(v, t); err != nil {
+			return nil, err
+		}
 		return data, nil
 	case "<data>":
 		body, etag, data := next(data)
@@ -199,24 +153,320 @@ func unmarshalValue(data []byte, v reflect.Value) (rest []byte, err error) {
 		if string(etag) != "</data>" {
 			return nil, fmt.Errorf("expected </data> but got %s", etag)
 		}
-		d, err := base64.StdEncoding.DecodeString(string(body))
+		d, err := decodeBase64(body)
 		if err != nil {
 			return nil, fmt.Errorf("non-base64 in <data> tag: %s", body)
 		}
-		v.Set(reflect.ValueOf(d))
+		if err := setBytes(v, d); err != nil {
+			return nil, err
+		}
 		return data, nil
 	case "<true/>":
-		b := true
-		v.Set(reflect.ValueOf(b))
+		if err := setBool(v, true); err != nil {
+			return nil, err
+		}
 		return data, nil
 	case "<false/>":
-		b := false
-		v.Set(reflect.ValueOf(b))
+		if err := setBool(v, false); err != nil {
+			return nil, err
+		}
 		return data, nil
 	}
 	return nil, fmt.Errorf("unexpected tag %s", tag)
 }
 
+// unmarshalDict unmarshals a <dict> whose opening tag has already been
+// consumed into v, which must be a struct, a map[string]T, or an
+// interface{} (populated with a map[string]interface{}).
+func unmarshalDict(data []byte, v reflect.Value) (rest []byte, err error) {
+	if v.Kind() == reflect.Interface {
+		m := reflect.New(reflect.TypeOf(map[string]interface{}{})).Elem()
+		data, err := unmarshalDict(data, m)
+		if err != nil {
+			return nil, err
+		}
+		v.Set(m)
+		return data, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+	Dict:
+		for {
+			_, tag, data2 := next(data)
+			data = data2
+			if len(tag) == 0 {
+				return nil, fmt.Errorf("eof inside <dict>")
+			}
+			if string(tag) == "</dict>" {
+				return data, nil
+			}
+			if string(tag) != "<key>" {
+				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
+			}
+			var body []byte
+			body, tag, data = next(data)
+			if len(tag) == 0 {
+				return nil, fmt.Errorf("eof inside <dict>")
+			}
+			if string(tag) != "</key>" {
+				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
+			}
+			name := string(body)
+			if i, ok := plistFieldByName(t, name); ok {
+				data, err = unmarshalValue(data, v.Field(i))
+				if err != nil {
+					return nil, err
+				}
+				continue Dict
+			}
+			data, err = skipValue(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		t := v.Type()
+		for {
+			_, tag, data2 := next(data)
+			data = data2
+			if len(tag) == 0 {
+				return nil, fmt.Errorf("eof inside <dict>")
+			}
+			if string(tag) == "</dict>" {
+				return data, nil
+			}
+			if string(tag) != "<key>" {
+				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
+			}
+			var body []byte
+			body, tag, data = next(data)
+			if len(tag) == 0 {
+				return nil, fmt.Errorf("eof inside <dict>")
+			}
+			if string(tag) != "</key>" {
+				return nil, fmt.Errorf("unexpected tag %s inside <dict>", tag)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			data, err = unmarshalValue(data, elem)
+			if err != nil {
+				return nil, err
+			}
+			v.SetMapIndex(reflect.ValueOf(string(body)), elem)
+		}
+	}
+	return nil, fmt.Errorf("cannot unmarshal <dict> into non-struct/map %s", v.Type())
+}
+
+// unmarshalArray unmarshals an <array> whose opening tag has already been
+// consumed into v, which must be a slice or an interface{} (populated
+// with a []interface{}).
+func unmarshalArray(data []byte, v reflect.Value) (rest []byte, err error) {
+	if v.Kind() == reflect.Interface {
+		s := reflect.New(reflect.TypeOf([]interface{}{})).Elem()
+		data, err := unmarshalArray(data, s)
+		if err != nil {
+			return nil, err
+		}
+		v.Set(s)
+		return data, nil
+	}
+
+	if v.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("cannot unmarshal <array> into non-slice %s", v.Type())
+	}
+	t := v.Type()
+	for {
+		_, tag, rest := next(data)
+		if len(tag) == 0 {
+			return nil, fmt.Errorf("eof inside <array>")
+		}
+		if string(tag) == "</array>" {
+			return rest, nil
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		data, err = unmarshalValue(data, elem)
+		if err != nil {
+			return nil, err
+		}
+		v.Set(reflect.Append(v, elem))
+	}
+}
+
+// plistFieldByName returns the index of t's field matching a <key> or
+// dict entry named name, checked against both the Go field name and the
+// name part of its `plist:"name,omitempty"` tag, as plistFieldName reads
+// it for Marshal. It is shared by the XML, binary, and keyed-archive
+// decoders so all three match struct fields the same way.
+func plistFieldByName(t reflect.Type, name string) (int, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tagName, _ := plistFieldName(f); f.Name == name || tagName == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// setInt assigns i into v, which may be any int/uint width or an
+// interface{} (populated with a plain int, plist's canonical integer
+// type).
+func setInt(v reflect.Value, i int64) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(int(i)))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(i) {
+			return fmt.Errorf("integer %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i < 0 || v.OverflowUint(uint64(i)) {
+			return fmt.Errorf("integer %d overflows %s", i, v.Type())
+		}
+		v.SetUint(uint64(i))
+	default:
+		return fmt.Errorf("cannot unmarshal <integer> into %s", v.Type())
+	}
+	return nil
+}
+
+// setFloat assigns f into v, which may be float32, float64, or an
+// interface{} (populated with a plain float64).
+func setFloat(v reflect.Value, f float64) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(f))
+	case reflect.Float32, reflect.Float64:
+		if v.OverflowFloat(f) {
+			return fmt.Errorf("real %v overflows %s", f, v.Type())
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot unmarshal <real> into %s", v.Type())
+	}
+	return nil
+}
+
+// setString assigns s into v, which may be a string or an interface{}.
+func setString(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(s))
+	case reflect.String:
+		v.SetString(s)
+	default:
+		return fmt.Errorf("cannot unmarshal <string> into %s", v.Type())
+	}
+	return nil
+}
+
+// setTime assigns t into v, which must be time.Time or an interface{}.
+func setTime(v reflect.Value, t time.Time) error {
+	if v.Kind() == reflect.Interface || v.Type() == timeType {
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal <date> into %s", v.Type())
+}
+
+// setBytes assigns b into v, which may be []byte (or another byte slice
+// type) or an interface{}.
+func setBytes(v reflect.Value, b []byte) error {
+	switch {
+	case v.Kind() == reflect.Interface:
+		v.Set(reflect.ValueOf(b))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(b)
+	default:
+		return fmt.Errorf("cannot unmarshal <data> into %s", v.Type())
+	}
+	return nil
+}
+
+// setBool assigns b into v, which may be a bool or an interface{}.
+func setBool(v reflect.Value, b bool) error {
+	switch v.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(b))
+	case reflect.Bool:
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("cannot unmarshal <true/>/<false/> into %s", v.Type())
+	}
+	return nil
+}
+
+// unescapePlistString decodes the XML entity references plutil writes
+// inside <string> bodies: the five named entities plus decimal and
+// hexadecimal numeric character references.
+func unescapePlistString(s []byte) (string, error) {
+	if !bytes.ContainsRune(s, '&') {
+		return string(s), nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(s); {
+		if s[i] != '&' {
+			buf.WriteByte(s[i])
+			i++
+			continue
+		}
+		j := bytes.IndexByte(s[i:], ';')
+		if j < 0 {
+			return "", fmt.Errorf("plist: unterminated entity reference")
+		}
+		j += i
+		ent := string(s[i+1 : j])
+		switch {
+		case ent == "amp":
+			buf.WriteByte('&')
+		case ent == "lt":
+			buf.WriteByte('<')
+		case ent == "gt":
+			buf.WriteByte('>')
+		case ent == "quot":
+			buf.WriteByte('"')
+		case ent == "apos":
+			buf.WriteByte('\'')
+		case strings.HasPrefix(ent, "#x") || strings.HasPrefix(ent, "#X"):
+			n, err := strconv.ParseInt(ent[2:], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("plist: invalid numeric character reference &%s;", ent)
+			}
+			buf.WriteRune(rune(n))
+		case strings.HasPrefix(ent, "#"):
+			n, err := strconv.ParseInt(ent[1:], 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("plist: invalid numeric character reference &%s;", ent)
+			}
+			buf.WriteRune(rune(n))
+		default:
+			return "", fmt.Errorf("plist: unknown entity reference &%s;", ent)
+		}
+		i = j + 1
+	}
+	return buf.String(), nil
+}
+
+// decodeBase64 decodes a <data> body, tolerating the whitespace and
+// newlines plutil inserts when wrapping long blocks.
+func decodeBase64(body []byte) ([]byte, error) {
+	clean := make([]byte, 0, len(body))
+	for _, c := range body {
+		switch c {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		clean = append(clean, c)
+	}
+	return base64.StdEncoding.DecodeString(string(clean))
+}
+
 func skipValue(data []byte) (rest []byte, err error) {
 	n := 0
 	for {
@@ -0,0 +1,145 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestBinaryRoundTrip guards against the binary writer emitting negative
+// integers in a 1/2/4-byte width, which the reader zero-extends into
+// large positive values.
+func TestBinaryRoundTrip(t *testing.T) {
+	type Ints struct {
+		Pos int64
+		Neg int64
+		Big int64
+	}
+	in := Ints{Pos: 42, Neg: -1, Big: -1000000}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetFormat(FormatBinary)
+	if err := e.Encode(&in); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var out Ints
+	if err := Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("binary round trip not equal (%#v != %#v)", in, out)
+	}
+}
+
+// TestOpenStepRoundTrip exercises FormatOpenStep through the full
+// encode/decode cycle, including the GNUstep typed scalars writeOpenStep
+// emits for ints and bools.
+func TestOpenStepRoundTrip(t *testing.T) {
+	type Simple struct {
+		Name  string
+		Count int
+		OK    bool
+	}
+	in := Simple{Name: "hello world", Count: 7, OK: true}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.SetFormat(FormatOpenStep)
+	if err := e.Encode(&in); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	var out Simple
+	if err := Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("OpenStep round trip not equal (%#v != %#v)", in, out)
+	}
+}
+
+// TestMarshalMap guards marshalValue's reflect.Map case.
+func TestMarshalMap(t *testing.T) {
+	in := map[string]interface{}{"a": "b", "c": 3}
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out map[string]interface{}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out["a"] != "b" || out["c"] != 3 {
+		t.Errorf("map round trip not equal (%#v != %#v)", in, out)
+	}
+}
+
+// TestUnmarshalKeyedArchive exercises the $objects/$top/$class walk
+// against a hand-built NSKeyedArchiver-style document in OpenStep syntax,
+// including a CF$UID reference resolving into a registered class.
+func TestUnmarshalKeyedArchive(t *testing.T) {
+	type Dog struct {
+		Name string
+		Age  int
+	}
+	RegisterClass("Dog", reflect.TypeOf(Dog{}))
+
+	data := []byte(`{
+		"$archiver" = "NSKeyedArchiver";
+		"$top" = { "root" = { "CF$UID" = <*I1>; }; };
+		"$objects" = (
+			"$null",
+			{ "$class" = { "CF$UID" = <*I2>; }; "Name" = "Fido"; "Age" = <*I3>; },
+			{ "$classname" = "Dog"; }
+		);
+	}`)
+
+	var dog Dog
+	if err := UnmarshalKeyedArchive(data, &dog); err != nil {
+		t.Fatalf("UnmarshalKeyedArchive: %v", err)
+	}
+	want := Dog{Name: "Fido", Age: 3}
+	if !reflect.DeepEqual(want, dog) {
+		t.Errorf("keyed archive decode not equal (%#v != %#v)", want, dog)
+	}
+}
+
+// TestAssignPlistValueTypeMismatch guards assignPlistValue's scalar cases
+// against the panic reflect.Value.Set raises when the decoded object's
+// type doesn't match the destination field: a mismatch must surface as an
+// error, since the binary, text, and keyed-archive decoders all route
+// untrusted input through this function.
+func TestAssignPlistValueTypeMismatch(t *testing.T) {
+	var n int
+	if err := assignPlistValue("not an int", reflect.ValueOf(&n).Elem()); err == nil {
+		t.Error("assignPlistValue(string, *int): expected error, got nil")
+	}
+
+	var b bool
+	if err := assignPlistValue("not a bool", reflect.ValueOf(&b).Elem()); err == nil {
+		t.Error("assignPlistValue(string, *bool): expected error, got nil")
+	}
+}
+
+// TestUnmarshalValueTypeMismatch is the XML-decoder counterpart: a
+// <string>/<date>/<data>/<true/> tag decoded into an incompatible field
+// must return an error instead of panicking.
+func TestUnmarshalValueTypeMismatch(t *testing.T) {
+	var n int
+	if _, err := unmarshalValue([]byte(`<string>hello</string>`), reflect.ValueOf(&n).Elem()); err == nil {
+		t.Error("unmarshalValue(<string>, *int): expected error, got nil")
+	}
+
+	var s string
+	if _, err := unmarshalValue([]byte(`<true/>`), reflect.ValueOf(&s).Elem()); err == nil {
+		t.Error("unmarshalValue(<true/>, *string): expected error, got nil")
+	}
+}
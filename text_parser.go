@@ -0,0 +1,291 @@
+// Copyright 2012 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// unmarshalText decodes an OpenStep/ASCII (NeXT) plist document into v,
+// using the same field-matching rules as the XML and binary decoders.
+func unmarshalText(data []byte, v reflect.Value) error {
+	p := &textParser{data: data}
+	p.skipSpace()
+	obj, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return fmt.Errorf("text plist: junk after value")
+	}
+	return assignPlistValue(obj, v)
+}
+
+// textParser is a hand-written recursive-descent parser for the legacy
+// NeXT/OpenStep ASCII plist syntax, including the GNUstep extensions for
+// typed scalars (<*I42>, <*R3.14>, <*BY>/<*BN>, <*D...>).
+type textParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *textParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.pos++
+		case c == '/' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '/':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '/' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '*':
+			p.pos += 2
+			for p.pos+1 < len(p.data) && !(p.data[p.pos] == '*' && p.data[p.pos+1] == '/') {
+				p.pos++
+			}
+			p.pos += 2
+		default:
+			return
+		}
+	}
+}
+
+func (p *textParser) parseValue() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, fmt.Errorf("text plist: unexpected end of data")
+	}
+	switch p.data[p.pos] {
+	case '{':
+		return p.parseDict()
+	case '(':
+		return p.parseArray()
+	case '<':
+		return p.parseAngle()
+	case '"':
+		return p.parseQuotedString()
+	default:
+		return p.parseUnquotedString()
+	}
+}
+
+func (p *textParser) parseDict() (interface{}, error) {
+	p.pos++ // '{'
+	d := &bplistDict{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside dict")
+		}
+		if p.data[p.pos] == '}' {
+			p.pos++
+			return d, nil
+		}
+		keyObj, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyObj.(string)
+		if !ok {
+			return nil, fmt.Errorf("text plist: dict key must be a string")
+		}
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != '=' {
+			return nil, fmt.Errorf("text plist: expected '=' after dict key %q", key)
+		}
+		p.pos++
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.data) || p.data[p.pos] != ';' {
+			return nil, fmt.Errorf("text plist: expected ';' after value for key %q", key)
+		}
+		p.pos++
+		d.keys = append(d.keys, key)
+		d.vals = append(d.vals, val)
+	}
+}
+
+func (p *textParser) parseArray() (interface{}, error) {
+	p.pos++ // '('
+	arr := []interface{}{}
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ')' {
+		p.pos++
+		return arr, nil
+	}
+	for {
+		val, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, val)
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside array")
+		}
+		switch p.data[p.pos] {
+		case ',':
+			p.pos++
+			p.skipSpace()
+			if p.pos < len(p.data) && p.data[p.pos] == ')' {
+				p.pos++
+				return arr, nil
+			}
+		case ')':
+			p.pos++
+			return arr, nil
+		default:
+			return nil, fmt.Errorf("text plist: expected ',' or ')' in array")
+		}
+	}
+}
+
+// parseAngle parses either <hex data> or a GNUstep typed scalar
+// (<*I42>, <*R3.14>, <*BY>, <*BN>, <*D2020-01-01 00:00:00 +0000>).
+func (p *textParser) parseAngle() (interface{}, error) {
+	p.pos++ // '<'
+	if p.pos < len(p.data) && p.data[p.pos] == '*' {
+		p.pos++
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside typed scalar")
+		}
+		kind := p.data[p.pos]
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.data) && p.data[p.pos] != '>' {
+			p.pos++
+		}
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: unterminated typed scalar")
+		}
+		body := string(p.data[start:p.pos])
+		p.pos++ // '>'
+		switch kind {
+		case 'I':
+			n, err := strconv.ParseInt(body, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("text plist: invalid <*I%s>: %v", body, err)
+			}
+			return n, nil
+		case 'R':
+			f, err := strconv.ParseFloat(body, 64)
+			if err != nil {
+				return nil, fmt.Errorf("text plist: invalid <*R%s>: %v", body, err)
+			}
+			return f, nil
+		case 'B':
+			switch body {
+			case "Y":
+				return true, nil
+			case "N":
+				return false, nil
+			}
+			return nil, fmt.Errorf("text plist: invalid <*B%s>", body)
+		case 'D':
+			t, err := time.Parse("2006-01-02 15:04:05 -0700", body)
+			if err != nil {
+				return nil, fmt.Errorf("text plist: invalid <*D%s>: %v", body, err)
+			}
+			return t, nil
+		}
+		return nil, fmt.Errorf("text plist: unknown typed scalar <*%c...>", kind)
+	}
+
+	var buf []byte
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside data")
+		}
+		if p.data[p.pos] == '>' {
+			p.pos++
+			return buf, nil
+		}
+		if p.pos+2 > len(p.data) {
+			return nil, fmt.Errorf("text plist: truncated hex byte in data")
+		}
+		b, err := strconv.ParseUint(string(p.data[p.pos:p.pos+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("text plist: invalid hex byte in data: %v", err)
+		}
+		buf = append(buf, byte(b))
+		p.pos += 2
+	}
+}
+
+func (p *textParser) parseQuotedString() (interface{}, error) {
+	p.pos++ // opening '"'
+	var buf []byte
+	for {
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside quoted string")
+		}
+		c := p.data[p.pos]
+		if c == '"' {
+			p.pos++
+			return string(buf), nil
+		}
+		if c != '\\' {
+			buf = append(buf, c)
+			p.pos++
+			continue
+		}
+		p.pos++
+		if p.pos >= len(p.data) {
+			return nil, fmt.Errorf("text plist: eof inside quoted string escape")
+		}
+		switch e := p.data[p.pos]; e {
+		case 'n':
+			buf = append(buf, '\n')
+		case 't':
+			buf = append(buf, '\t')
+		case 'r':
+			buf = append(buf, '\r')
+		case '"', '\\':
+			buf = append(buf, e)
+		case 'U':
+			if p.pos+4 >= len(p.data) {
+				return nil, fmt.Errorf("text plist: truncated \\U escape")
+			}
+			n, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+5]), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("text plist: invalid \\U escape: %v", err)
+			}
+			buf = append(buf, []byte(string(rune(n)))...)
+			p.pos += 4
+		default:
+			buf = append(buf, e)
+		}
+		p.pos++
+	}
+}
+
+func isUnquotedStringChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	case c == '.' || c == '_' || c == '$' || c == '/' || c == ':' || c == '-':
+		return true
+	}
+	return false
+}
+
+func (p *textParser) parseUnquotedString() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.data) && isUnquotedStringChar(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return nil, fmt.Errorf("text plist: unexpected character %q", p.data[p.pos])
+	}
+	return string(p.data[start:p.pos]), nil
+}